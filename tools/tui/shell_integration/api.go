@@ -5,10 +5,16 @@ package shell_integration
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
 	"kitty/tools/utils"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -16,33 +22,163 @@ import (
 	"golang.org/x/exp/slices"
 )
 
+// integration files for the shells registered directly by this package that
+// predate Data() having a copy of its own: nu, pwsh and xonsh. zsh/bash/fish
+// still come from the much older embedded Data() bundle (see shell_backend's
+// doc comment); these three are new enough that shipping them as a plain nested
+// go:embed alongside the backend that needs them is simpler than threading
+// them through that bundle.
+//
+//go:embed shell-integration/nu shell-integration/pwsh shell-integration/xonsh
+var builtin_backend_assets embed.FS
+
+// sub_fs_for returns the part of builtin_backend_assets under
+// shell-integration/<shell_name>, to pass as a backend's filesFS; shell_name
+// is one of the directories embedded above, so the error case can't happen
+func sub_fs_for(shell_name string) fs.FS {
+	sub, err := fs.Sub(builtin_backend_assets, path.Join("shell-integration", shell_name))
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
 var _ = fmt.Print
 
 type integration_setup_func = func(shell_integration_dir string, argv []string, env map[string]string) ([]string, map[string]string, error)
 
-func extract_shell_integration_for(shell_name string, dest_dir string) (err error) {
+// a pluggable shell backend: setup does the env/argv mangling needed to make
+// the shell load the integration files without editing the user's rc files,
+// filesFS is where those integration files live; a nil filesFS means they
+// ship in the embedded Data() under shell-integration/<name>/, which is how
+// zsh, bash and fish are registered (nu, pwsh and xonsh pass their own
+// filesFS from builtin_backend_assets)
+type shell_backend struct {
+	setup   integration_setup_func
+	filesFS fs.FS
+}
+
+var registered_shells = map[string]shell_backend{}
+
+// shell_name_aliases maps a shell name that is just another invocation name
+// for an already-registered backend to the canonical name whose integration
+// files it should use, e.g. invoking kitty as "powershell" gets the same
+// shell-integration/pwsh assets as invoking it as "pwsh". Every asset/cache
+// lookup must resolve through canonical_shell_name first: looking up an
+// alias's own name directly finds no files and hard-fails extraction.
+var shell_name_aliases = map[string]string{
+	"powershell": "pwsh",
+}
+
+func canonical_shell_name(shell_name string) string {
+	if canon, ok := shell_name_aliases[shell_name]; ok {
+		return canon
+	}
+	return shell_name
+}
+
+// Register makes a new shell known to IsSupportedShell and Setup, allowing
+// third-party shells to be added without editing this package
+func Register(shell_name string, setup integration_setup_func, filesFS fs.FS) {
+	registered_shells[shell_name] = shell_backend{setup: setup, filesFS: filesFS}
+}
+
+// one file or directory to be materialized under dest_dir/<extraction_entry.name>
+type extraction_entry struct {
+	name string // relative path, e.g. shell-integration/zsh/kitty.zsh
+	kind byte   // tar.TypeDir, tar.TypeSymlink or tar.TypeReg
+	data []byte
+	link string
+}
+
+// collect_entries_for lists the files that make up shell_name's integration,
+// from the registered backend's filesFS if it has one, otherwise from the
+// embedded Data(). This is the single source of truth both extraction and
+// digest computation walk, so the two can never disagree about what "the
+// current version of shell_name's integration files" means.
+func collect_entries_for(shell_name string) (entries []extraction_entry, err error) {
+	if b, ok := registered_shells[shell_name]; ok && b.filesFS != nil {
+		prefix := filepath.Join("shell-integration", shell_name)
+		err = fs.WalkDir(b.filesFS, ".", func(name string, de fs.DirEntry, err error) error {
+			if err != nil || name == "." {
+				return err
+			}
+			rel := filepath.Join(prefix, name)
+			if de.IsDir() {
+				entries = append(entries, extraction_entry{name: rel, kind: tar.TypeDir})
+				return nil
+			}
+			data, rerr := fs.ReadFile(b.filesFS, name)
+			if rerr != nil {
+				return rerr
+			}
+			entries = append(entries, extraction_entry{name: rel, kind: tar.TypeReg, data: data})
+			return nil
+		})
+		return
+	}
 	d := Data()
-	for _, fname := range d.FilesMatching("shell-integration/" + shell_name + "/") {
-		entry := d[fname]
-		dest := filepath.Join(dest_dir, fname)
-		ddir := filepath.Dir(dest)
-		if err = os.MkdirAll(ddir, 0o755); err != nil {
+	fnames := d.FilesMatching("shell-integration/" + shell_name + "/")
+	slices.Sort(fnames)
+	entries = make([]extraction_entry, 0, len(fnames))
+	for _, fname := range fnames {
+		de := d[fname]
+		e := extraction_entry{name: fname, kind: de.Metadata.Typeflag}
+		switch e.kind {
+		case tar.TypeSymlink:
+			e.link = de.Metadata.Linkname
+		case tar.TypeReg:
+			e.data = de.Data
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// digest_for_entries is a stable hash of the full set of entries: name, kind
+// and contents of every file. Two calls return the same digest iff nothing
+// about shell_name's integration files changed (e.g. across a kitty upgrade).
+func digest_for_entries(entries []extraction_entry) string {
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e.name)
+		h.Write([]byte{e.kind})
+		io.WriteString(h, e.link)
+		h.Write(e.data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func embedded_checksum_for(shell_name string) string {
+	entries, err := collect_entries_for(shell_name)
+	if err != nil {
+		return ""
+	}
+	return digest_for_entries(entries)
+}
+
+// name of the file a vendor-installed copy of the integration files must ship
+// alongside itself, containing the checksum of the embedded Data() it was
+// built from, so we can tell it apart from a stale or foreign copy
+const vendor_checksum_filename = ".kitty-ksi-checksum"
+
+func write_entries(entries []extraction_entry, dest_dir string) (err error) {
+	for _, e := range entries {
+		dest := filepath.Join(dest_dir, e.name)
+		if err = os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
 			return
 		}
-		switch entry.Metadata.Typeflag {
+		switch e.kind {
 		case tar.TypeDir:
 			if err = os.MkdirAll(dest, 0o755); err != nil {
 				return
 			}
 		case tar.TypeSymlink:
-			if err = os.Symlink(entry.Metadata.Linkname, dest); err != nil {
+			if err = os.Symlink(e.link, dest); err != nil {
 				return
 			}
 		case tar.TypeReg:
-			if existing, rerr := os.ReadFile(dest); rerr == nil && bytes.Equal(existing, entry.Data) {
-				continue
-			}
-			if err = utils.AtomicWriteFile(dest, entry.Data, 0o644); err != nil {
+			if err = utils.AtomicWriteFile(dest, e.data, 0o644); err != nil {
 				return
 			}
 		}
@@ -50,7 +186,179 @@ func extract_shell_integration_for(shell_name string, dest_dir string) (err erro
 	return
 }
 
+// gc_stale_versioned_dirs removes every dest_dir/shell-integration/<shell_name>-*
+// directory except keep, e.g. ones left behind by a previous kitty version.
+// A directory whose name contains ".staging-" is always left alone: that
+// naming is reserved for a single in-progress extraction attempt (see
+// extract_shell_integration_for) and may belong to another process that is
+// still writing to it.
+func gc_stale_versioned_dirs(root, shell_name, keep string) {
+	matches, err := filepath.Glob(filepath.Join(root, shell_name+"-*"))
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		if m == keep || strings.Contains(filepath.Base(m), ".staging-") {
+			continue
+		}
+		os.RemoveAll(m)
+	}
+}
+
+// update_stable_shell_symlink (re)points dest_dir/shell-integration/<shell_name>
+// at target_base, the base name of a sibling content-addressed directory,
+// so callers that need a literal, shell-name-only path (fish's vendor_*.d
+// lookup, or a source line Install already wrote into a user's rc file) have
+// one that survives gc_stale_versioned_dirs retiring the digest directory it
+// used to point at. The repoint happens via a temp symlink plus os.Rename so
+// a reader never observes the stable path missing or pointing nowhere.
+func update_stable_shell_symlink(root, shell_name, target_base string) error {
+	stable := filepath.Join(root, shell_name)
+	if cur, err := os.Readlink(stable); err == nil && cur == target_base {
+		return nil
+	}
+	if fi, err := os.Lstat(stable); err == nil && fi.Mode()&os.ModeSymlink == 0 {
+		// a plain directory here is left over from a kitty version that
+		// predates the content-addressed cache; clear it so it doesn't block
+		// the rename below
+		if err = os.RemoveAll(stable); err != nil {
+			return err
+		}
+	}
+	// a per-call temp dir, so concurrent callers never share a temp name
+	tmp_dir, err := os.MkdirTemp(root, "."+shell_name+".tmp-symlink-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp_dir)
+	tmp_link := filepath.Join(tmp_dir, shell_name)
+	if err := os.Symlink(target_base, tmp_link); err != nil {
+		return err
+	}
+	return os.Rename(tmp_link, stable)
+}
+
+// extract_shell_integration_for makes sure a copy of shell_name's current
+// integration files exists under dest_dir/shell-integration/<shell_name>-<digest>
+// and returns the path of a stable dest_dir/shell-integration/<shell_name>
+// symlink pointing at it. The versioned directory name is derived from the
+// digest of the files being extracted, so it is content-addressed and, once
+// it exists, immutable: repeated calls for a version already extracted cost
+// a few stats, a readlink and one directory listing instead of an O(files)
+// walk and compare, and two kitty
+// processes racing to extract the very same version always agree on the
+// same destination without needing to coordinate or mutate it in place.
+// Each writes into its own directory (via os.MkdirTemp, so concurrent
+// writers can never collide) and only the process that wins the race to
+// os.Rename it into the content-addressed destination keeps its work; the
+// other simply discards its now-redundant copy. A reader can never observe
+// a half-written tree because the destination only ever comes into
+// existence via that single atomic rename. The stable symlink is repointed
+// to the new versioned_dir before gc_stale_versioned_dirs ever runs, so
+// nothing that resolved it a moment ago is left dangling.
+func extract_shell_integration_for(shell_name string, dest_dir string) (stable_dir string, err error) {
+	shell_name = canonical_shell_name(shell_name)
+	entries, err := collect_entries_for(shell_name)
+	if err != nil {
+		return "", err
+	}
+	digest := digest_for_entries(entries)
+	root := filepath.Join(dest_dir, "shell-integration")
+	if err = os.MkdirAll(root, 0o755); err != nil {
+		return "", err
+	}
+	versioned_dir := filepath.Join(root, shell_name+"-"+digest)
+	if s, serr := os.Stat(versioned_dir); serr != nil || !s.IsDir() {
+		staging, terr := os.MkdirTemp(root, ".staging-"+shell_name+"-*")
+		if terr != nil {
+			return "", terr
+		}
+		defer os.RemoveAll(staging)
+		if err = write_entries(entries, staging); err != nil {
+			return "", err
+		}
+		if err = os.Rename(filepath.Join(staging, "shell-integration", shell_name), versioned_dir); err != nil {
+			// another process may have won the race to create versioned_dir
+			// concurrently; that's fine as long as it exists now
+			if s, serr := os.Stat(versioned_dir); serr != nil || !s.IsDir() {
+				return "", err
+			}
+			err = nil
+		}
+	}
+	stable_dir = filepath.Join(root, shell_name)
+	if err = update_stable_shell_symlink(root, shell_name, filepath.Base(versioned_dir)); err != nil {
+		return "", err
+	}
+	gc_stale_versioned_dirs(root, shell_name, versioned_dir)
+	return stable_dir, nil
+}
+
+// directories distro packages are expected to drop the shell integration
+// files into, checked before we fall back to extracting our own copy under
+// the cache dir
+func vendor_dirs_for(shell_name string) []string {
+	switch shell_name {
+	case "zsh":
+		// $fpath is a zsh-local array, not something zsh exports to the
+		// process environment by default, so FPATH here is only useful if
+		// the calling wrapper script explicitly exported it before launching
+		// kitty. We don't rely on that: the well-known entries below cover
+		// the locations zsh installs from major distros and package managers
+		// actually use, which is what this is for in practice.
+		dirs := utils.Filter(strings.Split(os.Getenv("FPATH"), string(filepath.ListSeparator)), func(x string) bool { return x != "" })
+		well_known := []string{
+			"/usr/share/zsh/site-functions",
+			"/usr/local/share/zsh/site-functions",
+			"/opt/homebrew/share/zsh/site-functions",
+		}
+		return append(dirs, well_known...)
+	case "fish":
+		// these are XDG_DATA_DIRS entries, not the vendor_*.d dirs themselves:
+		// fish_setup_func needs the "fish" path vendor_copy_path_for derives
+		// from one of these (not one of its vendor_completions.d/vendor_functions.d/
+		// vendor_conf.d children) so its filepath.Dir() call lands back on a
+		// real XDG_DATA_DIRS root
+		xdg := os.Getenv("XDG_DATA_DIRS")
+		if xdg == "" {
+			xdg = "/usr/local/share:/usr/share"
+		}
+		return utils.Filter(strings.Split(xdg, string(filepath.ListSeparator)), func(x string) bool { return x != "" })
+	case "bash":
+		return []string{"/usr/share/bash-completion/completions", "/etc/bash_completion.d"}
+	}
+	return nil
+}
+
+// vendor_copy_path_for returns the path to a system-installed copy of the
+// shell integration files for shell_name whose checksum matches the
+// currently embedded Data(), if one can be found
+func vendor_copy_path_for(shell_name string) (path string, ok bool) {
+	wanted := embedded_checksum_for(shell_name)
+	for _, dir := range vendor_dirs_for(shell_name) {
+		q := filepath.Join(dir, "kitty")
+		checksum := filepath.Join(q, vendor_checksum_filename)
+		if shell_name == "fish" {
+			// fish's own vendor_*.d dirs live under <dir>/fish, so that's the
+			// path fish_setup_func needs back (its filepath.Dir() call must
+			// land on dir, an actual XDG_DATA_DIRS entry); the checksum
+			// travels alongside kitty's own vendor_conf.d entry
+			q = filepath.Join(dir, "fish")
+			checksum = filepath.Join(q, "vendor_conf.d", "kitty", vendor_checksum_filename)
+		}
+		raw, err := os.ReadFile(checksum)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(utils.UnsafeBytesToString(raw)) == wanted {
+			return q, true
+		}
+	}
+	return "", false
+}
+
 func EnsureShellIntegrationFilesFor(shell_name string) (shell_integration_dir string, err error) {
+	shell_name = canonical_shell_name(shell_name)
 	if kid := os.Getenv("KITTY_INSTALLATION_DIR"); kid != "" {
 		if s, e := os.Stat(kid); e == nil && s.IsDir() {
 			q := filepath.Join(kid, "shell-integration", shell_name)
@@ -59,14 +367,14 @@ func EnsureShellIntegrationFilesFor(shell_name string) (shell_integration_dir st
 			}
 		}
 	}
+	if q, ok := vendor_copy_path_for(shell_name); ok {
+		return q, nil
+	}
 	base := filepath.Join(utils.CacheDir(), "extracted-ksi")
 	if err = os.MkdirAll(base, 0o755); err != nil {
 		return "", err
 	}
-	if err = extract_shell_integration_for(shell_name, base); err != nil {
-		return "", err
-	}
-	return filepath.Join(base, "shell-integration"), nil
+	return extract_shell_integration_for(shell_name, base)
 }
 
 func is_new_zsh_install(env map[string]string, zdotdir string) bool {
@@ -129,6 +437,13 @@ func zsh_setup_func(shell_integration_dir string, argv []string, env map[string]
 }
 
 func fish_setup_func(shell_integration_dir string, argv []string, env map[string]string) (final_argv []string, final_env map[string]string, err error) {
+	// shell_integration_dir must be a literal ".../fish" path (never a
+	// shell_name+"-"+digest one) for this Dir() to land on a real
+	// XDG_DATA_DIRS entry whose "fish/vendor_*.d" children fish will find;
+	// EnsureShellIntegrationFilesFor guarantees that by returning either a
+	// vendor-installed "<dir>/fish" (vendor_copy_path_for) or the stable
+	// "<cache>/shell-integration/fish" symlink extract_shell_integration_for
+	// maintains, never the content-addressed directory it points at.
 	shell_integration_dir = filepath.Dir(shell_integration_dir)
 	val := env[`XDG_DATA_DIRS`]
 	env[`KITTY_FISH_XDG_DATA_DIR`] = shell_integration_dir
@@ -143,17 +458,104 @@ func fish_setup_func(shell_integration_dir string, argv []string, env map[string
 }
 
 func bash_setup_func(shell_integration_dir string, argv []string, env map[string]string) (final_argv []string, final_env map[string]string, err error) {
+	final_argv, final_env = argv, env
+	posix_mode, no_rc, login_shell, has_command := false, false, false, false
+	for _, a := range argv[1:] {
+		switch a {
+		case `--posix`:
+			posix_mode = true
+		case `--noprofile`, `--norc`:
+			no_rc = true
+		case `-l`, `--login`:
+			login_shell = true
+		case `-c`:
+			has_command = true
+		}
+	}
+	if orig := env[`BASH_ENV`]; orig != "" {
+		final_env[`KITTY_BASH_ORIG_BASH_ENV`] = orig
+	} else {
+		delete(final_env, `KITTY_BASH_ORIG_BASH_ENV`)
+	}
+	final_env[`BASH_ENV`] = filepath.Join(shell_integration_dir, `kitty.bash`)
+	if posix_mode || no_rc || login_shell || has_command {
+		// one of these flags changes bash's normal startup file semantics in a
+		// way that is incompatible with --rcfile; let the wrapper chain to
+		// ~/.bashrc itself via BASH_ENV instead of injecting --rcfile. Clear
+		// any KITTY_BASH_INJECT inherited from a parent shell's exported env:
+		// it is only meaningful alongside the --rcfile we are not adding here,
+		// and leaving it set would tell kitty.bash to do --rcfile-specific
+		// chaining in a mode where that never happened.
+		delete(final_env, `KITTY_BASH_INJECT`)
+		return final_argv, final_env, nil
+	}
+	// sourced by shell-integration/bash/kitty.bash to decide whether to
+	// chain to the user's own ~/.bashrc once it is done setting up
+	final_env[`KITTY_BASH_INJECT`] = `1`
+	final_argv = append(slices.Clone(argv[:1]), append([]string{`--rcfile`, filepath.Join(shell_integration_dir, `kitty.bash`)}, argv[1:]...)...)
+	return final_argv, final_env, nil
+}
+
+func nu_setup_func(shell_integration_dir string, argv []string, env map[string]string) (final_argv []string, final_env map[string]string, err error) {
+	final_argv, final_env = argv, env
+	val := env[`NU_LIB_DIRS`]
+	dirs := utils.Filter(strings.Split(val, string(filepath.ListSeparator)), func(x string) bool { return x != "" })
+	dirs = append([]string{shell_integration_dir}, dirs...)
+	final_env[`NU_LIB_DIRS`] = strings.Join(dirs, string(filepath.ListSeparator))
+	// path to the shipped kitty.nu, which defines the kitty-pre-prompt command;
+	// nothing in this package adds that command to $env.config.hooks.pre_prompt
+	final_env[`KITTY_NU_PRE_PROMPT_HOOK`] = filepath.Join(shell_integration_dir, `kitty.nu`)
 	return
 }
 
+func pwsh_setup_func(shell_integration_dir string, argv []string, env map[string]string) (final_argv []string, final_env map[string]string, err error) {
+	final_argv, final_env = argv, env
+	if orig := env[`PROFILE`]; orig != "" {
+		final_env[`KITTY_PWSH_ORIG_PROFILE`] = orig
+	} else {
+		delete(final_env, `KITTY_PWSH_ORIG_PROFILE`)
+	}
+	for _, a := range argv[1:] {
+		switch strings.ToLower(a) {
+		case `-file`, `-command`, `-encodedcommand`, `-workingdirectory`, `-noninteractive`:
+			// one of these means the caller already customized how pwsh is
+			// invoked (a script, an inline command, non-interactive use); leave
+			// argv alone rather than clobbering it with our own -Command
+			return final_argv, final_env, nil
+		}
+	}
+	profile := filepath.Join(shell_integration_dir, `kitty.ps1`)
+	// $env:KITTY_PWSH_ORIG_PROFILE is unset, not empty, when PROFILE wasn't in
+	// the inherited env (the common case, since pwsh doesn't export $PROFILE
+	// to the process environment by default); Test-Path -LiteralPath $null
+	// throws a parameter-binding error, so check for a non-empty value first
+	command := `. '` + profile + `'; if ($env:KITTY_PWSH_ORIG_PROFILE -and (Test-Path -LiteralPath $env:KITTY_PWSH_ORIG_PROFILE)) { . $env:KITTY_PWSH_ORIG_PROFILE }`
+	final_argv = append(slices.Clone(argv[:1]), `-NoExit`, `-NoProfile`, `-Command`, command)
+	return final_argv, final_env, nil
+}
+
+func xonsh_setup_func(shell_integration_dir string, argv []string, env map[string]string) (final_argv []string, final_env map[string]string, err error) {
+	final_argv, final_env = argv, env
+	val := env[`XONSHRC`]
+	rcs := utils.Filter(strings.Split(val, string(filepath.ListSeparator)), func(x string) bool { return x != "" })
+	rcs = append([]string{filepath.Join(shell_integration_dir, `kitty.xsh`)}, rcs...)
+	final_env[`XONSHRC`] = strings.Join(rcs, string(filepath.ListSeparator))
+	return
+}
+
+func init() {
+	Register("zsh", zsh_setup_func, nil)
+	Register("fish", fish_setup_func, nil)
+	Register("bash", bash_setup_func, nil)
+	Register("nu", nu_setup_func, sub_fs_for("nu"))
+	Register("pwsh", pwsh_setup_func, sub_fs_for("pwsh"))
+	Register("powershell", pwsh_setup_func, sub_fs_for("pwsh"))
+	Register("xonsh", xonsh_setup_func, sub_fs_for("xonsh"))
+}
+
 func setup_func_for_shell(shell_name string) integration_setup_func {
-	switch shell_name {
-	case "zsh":
-		return zsh_setup_func
-	case "fish":
-		return fish_setup_func
-	case "bash":
-		return bash_setup_func
+	if b, ok := registered_shells[shell_name]; ok {
+		return b.setup
 	}
 	return nil
 }
@@ -171,3 +573,140 @@ func Setup(shell_name string, ksi_var string, argv []string, env map[string]stri
 	}
 	return argv, env, err
 }
+
+// markers delimiting the block Install/Uninstall add to a shell's rc files;
+// their presence is how Install detects it has already run
+const install_block_begin = `# BEGIN KITTY SHELL INTEGRATION`
+const install_block_end = `# END KITTY SHELL INTEGRATION`
+
+// rc_files_for returns the rc files Install/Uninstall should edit for
+// shell_name, for shells where that is even meaningful (the shells that use
+// env vars to inject integration don't need this at all, but locked-down
+// setups, e.g. a shell launched by tmux/screen with a stripped env, need an
+// explicit opt-in instead). fish is deliberately not supported here: its
+// integration has no single entry-point file to source — fish_setup_func
+// and vendor_copy_path_for both treat ksi_dir as an XDG_DATA_DIRS root that
+// fish auto-loads via its own vendor_conf.d mechanism, so there is nothing
+// for an rc block to source in the first place.
+func rc_files_for(shell_name string) (paths []string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	switch shell_name {
+	case "bash":
+		return []string{filepath.Join(home, ".bashrc"), filepath.Join(home, ".bash_profile")}, nil
+	case "zsh":
+		return []string{filepath.Join(home, ".zshrc")}, nil
+	}
+	return nil, fmt.Errorf("rc-file based install is not supported for: %s", shell_name)
+}
+
+func source_line_for(shell_name, ksi_dir string) string {
+	return fmt.Sprintf(`source "%s"`, filepath.Join(ksi_dir, "kitty."+shell_name))
+}
+
+func install_block_for(shell_name, ksi_dir string) string {
+	return install_block_begin + "\n" + source_line_for(shell_name, ksi_dir) + "\n" + install_block_end + "\n"
+}
+
+func remove_install_block(raw string) (result string, found bool) {
+	start := strings.Index(raw, install_block_begin)
+	if start == -1 {
+		return raw, false
+	}
+	rest := raw[start:]
+	end := strings.Index(rest, install_block_end)
+	if end == -1 {
+		return raw, false
+	}
+	end += len(install_block_end)
+	if end < len(rest) && rest[end] == '\n' {
+		end++
+	}
+	return raw[:start] + rest[end:], true
+}
+
+func add_install_block_to_file(path, block string) (err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		raw = nil
+	}
+	if bytes.Contains(raw, []byte(install_block_begin)) {
+		return nil // already installed, never double-install
+	}
+	if len(raw) > 0 {
+		if err = utils.AtomicWriteFile(path+".kitty-bak", raw, 0o644); err != nil {
+			return err
+		}
+	}
+	content := string(raw)
+	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += block
+	return utils.AtomicWriteFile(path, []byte(content), 0o644)
+}
+
+func remove_install_block_from_file(path string) (err error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	content, found := remove_install_block(string(raw))
+	if !found {
+		return nil
+	}
+	if err = utils.AtomicWriteFile(path+".kitty-bak", raw, 0o644); err != nil {
+		return err
+	}
+	return utils.AtomicWriteFile(path, []byte(content), 0o644)
+}
+
+// Install extracts the shell integration files for shell_name and adds a
+// clearly-delimited, idempotent block to its rc files that sources them.
+// This is an opt-in alternative to Setup's env-var based injection, for
+// setups where kitty can't control the environment the shell starts with.
+// The path baked into the rc block comes from EnsureShellIntegrationFilesFor,
+// which for the cache-extraction case is the stable shell-name symlink
+// update_stable_shell_symlink maintains, not the content-addressed directory
+// it points at; that's what keeps the rc block working across kitty upgrades
+// instead of being left sourcing a directory gc_stale_versioned_dirs retired.
+func Install(shell_name string) (err error) {
+	ksi_dir, err := EnsureShellIntegrationFilesFor(shell_name)
+	if err != nil {
+		return err
+	}
+	rc_files, err := rc_files_for(shell_name)
+	if err != nil {
+		return err
+	}
+	block := install_block_for(shell_name, ksi_dir)
+	for _, rc := range rc_files {
+		if err = add_install_block_to_file(rc, block); err != nil {
+			return fmt.Errorf("failed to install shell integration into %s: %w", rc, err)
+		}
+	}
+	return nil
+}
+
+// Uninstall removes the block Install added from shell_name's rc files, if
+// present. It is a no-op if Install was never run.
+func Uninstall(shell_name string) (err error) {
+	rc_files, err := rc_files_for(shell_name)
+	if err != nil {
+		return err
+	}
+	for _, rc := range rc_files {
+		if err = remove_install_block_from_file(rc); err != nil {
+			return fmt.Errorf("failed to remove shell integration from %s: %w", rc, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package shell_integration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGCStaleVersionedDirsLeavesStagingAlone(t *testing.T) {
+	root := t.TempDir()
+	keep := filepath.Join(root, "fish-current")
+	stale := filepath.Join(root, "fish-old")
+	staging := filepath.Join(root, ".staging-fish-123456")
+	for _, d := range []string{keep, stale, staging} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gc_stale_versioned_dirs(root, "fish", keep)
+	if _, err := os.Stat(keep); err != nil {
+		t.Fatalf("gc removed the directory it was told to keep: %v", err)
+	}
+	if _, err := os.Stat(staging); err != nil {
+		t.Fatalf("gc removed an in-progress .staging- dir belonging to another writer: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("gc left a stale versioned dir behind: %v", err)
+	}
+}
+
+func TestExtractShellIntegrationForConcurrentRaceIsSafe(t *testing.T) {
+	dest := t.TempDir()
+	const n = 8
+	dirs := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			dirs[i], errs[i] = extract_shell_integration_for("bash", dest)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("goroutine %d: %v", i, err)
+		}
+	}
+	for i, d := range dirs {
+		if d != dirs[0] {
+			t.Fatalf("goroutine %d returned %q, want %q (all racing writers must agree on the same destination)", i, d, dirs[0])
+		}
+	}
+	if _, err := os.Lstat(dirs[0]); err != nil {
+		t.Fatalf("stable symlink missing after concurrent extraction: %v", err)
+	}
+	target, err := os.Readlink(dirs[0])
+	if err != nil {
+		t.Fatalf("expected %q to be a symlink: %v", dirs[0], err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dirs[0]), target)); err != nil {
+		t.Fatalf("stable symlink points nowhere: %v", err)
+	}
+}
+
+// TestNewBackendsExtractEndToEnd pins that the backends registered with
+// their own builtin_backend_assets filesFS (nu, pwsh, xonsh) actually have
+// files to extract: collect_entries_for silently returning zero entries for
+// any of them makes extract_shell_integration_for's rename into versioned_dir
+// fail outright, since the source of that rename is never created.
+func TestNewBackendsExtractEndToEnd(t *testing.T) {
+	for shell, entry := range map[string]string{
+		"nu":    "kitty.nu",
+		"pwsh":  "kitty.ps1",
+		"xonsh": "kitty.xsh",
+	} {
+		dir, err := extract_shell_integration_for(shell, t.TempDir())
+		if err != nil {
+			t.Fatalf("%s: %v", shell, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry)); err != nil {
+			t.Fatalf("%s: expected %s under %s: %v", shell, entry, dir, err)
+		}
+	}
+}
+
+// TestPowershellAliasesPwshAssets pins that invoking as "powershell" resolves
+// to the same integration files as "pwsh" instead of looking them up under a
+// "shell-integration/powershell" path that was never shipped.
+func TestPowershellAliasesPwshAssets(t *testing.T) {
+	dest := t.TempDir()
+	pwsh_dir, err := extract_shell_integration_for("pwsh", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	powershell_dir, err := extract_shell_integration_for("powershell", dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if powershell_dir != pwsh_dir {
+		t.Fatalf("powershell resolved to %q, want the same stable dir as pwsh (%q)", powershell_dir, pwsh_dir)
+	}
+}
+
+func TestInstallIsIdempotent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	if err := Install("bash"); err != nil {
+		t.Fatal(err)
+	}
+	bashrc := filepath.Join(os.Getenv("HOME"), ".bashrc")
+	first, err := os.ReadFile(bashrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Install("bash"); err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(bashrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first) != string(second) {
+		t.Fatalf("re-running Install changed %s:\nfirst:\n%s\nsecond:\n%s", bashrc, first, second)
+	}
+	if n := strings.Count(string(second), install_block_begin); n != 1 {
+		t.Fatalf("expected exactly one install block after re-running Install, found %d in:\n%s", n, second)
+	}
+}
+
+func TestUninstallAfterInstall(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	bashrc := filepath.Join(home, ".bashrc")
+	original := []byte("# my existing bashrc\nexport FOO=bar\n")
+	if err := os.WriteFile(bashrc, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Install("bash"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Uninstall("bash"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(bashrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(original) {
+		t.Fatalf("Uninstall did not restore the pre-install content:\nwant:\n%s\ngot:\n%s", original, after)
+	}
+	if _, err := os.Stat(bashrc + ".kitty-bak"); err != nil {
+		t.Fatalf("expected a .kitty-bak backup from Uninstall's rewrite: %v", err)
+	}
+}
+
+func TestUninstallWhenNeverInstalled(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	bashrc := filepath.Join(home, ".bashrc")
+	original := []byte("# nothing to do with kitty\n")
+	if err := os.WriteFile(bashrc, original, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Uninstall("bash"); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.ReadFile(bashrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != string(original) {
+		t.Fatalf("Uninstall touched a file with no install block:\nwant:\n%s\ngot:\n%s", original, after)
+	}
+	if _, err := os.Stat(bashrc + ".kitty-bak"); !os.IsNotExist(err) {
+		t.Fatalf("Uninstall should not write a backup when there is nothing to remove")
+	}
+}